@@ -0,0 +1,64 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "time"
+)
+
+const defaultRequestTimeout = 30 * time.Second
+
+// newHTTPClient builds a *http.Client for a Node, configured once up front
+// instead of being allocated on every API call. It wires up TLS (CA file,
+// client certs, insecure_skip_verify), keep-alives and a request timeout.
+func newHTTPClient(node Node) (*http.Client, error) {
+    tlsConfig := &tls.Config{
+        InsecureSkipVerify: node.InsecureSkipVerify,
+    }
+
+    if node.CaFile != "" {
+        caCert, err := ioutil.ReadFile(node.CaFile)
+        if err != nil {
+            return nil, err
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(caCert) {
+            return nil, fmt.Errorf("no certificates found in ca_file %s", node.CaFile)
+        }
+        tlsConfig.RootCAs = pool
+    }
+
+    if node.ClientCertFile != "" && node.ClientKeyFile != "" {
+        cert, err := tls.LoadX509KeyPair(node.ClientCertFile, node.ClientKeyFile)
+        if err != nil {
+            return nil, err
+        }
+        tlsConfig.Certificates = []tls.Certificate{cert}
+    }
+
+    transport := &http.Transport{
+        TLSClientConfig: tlsConfig,
+        Dial: (&net.Dialer{
+            Timeout:   5 * time.Second,
+            KeepAlive: 30 * time.Second,
+        }).Dial,
+    }
+
+    timeout := defaultRequestTimeout
+    if node.Timeout != "" {
+        if dt, err := time.ParseDuration(node.Timeout); err == nil {
+            timeout = dt
+        } else {
+            log.Warn(err)
+        }
+    }
+
+    return &http.Client{
+        Transport: transport,
+        Timeout:   timeout,
+    }, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestNewHTTPClientDefaultTimeout(t *testing.T) {
+    client, err := newHTTPClient(Node{})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if client.Timeout != defaultRequestTimeout {
+        t.Errorf("Timeout = %v, want default %v", client.Timeout, defaultRequestTimeout)
+    }
+}
+
+func TestNewHTTPClientCustomTimeout(t *testing.T) {
+    client, err := newHTTPClient(Node{Timeout: "2s"})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if client.Timeout != 2*time.Second {
+        t.Errorf("Timeout = %v, want 2s", client.Timeout)
+    }
+}
+
+func TestNewHTTPClientUnparsableTimeoutFallsBackToDefault(t *testing.T) {
+    client, err := newHTTPClient(Node{Timeout: "not-a-duration"})
+    if err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+    if client.Timeout != defaultRequestTimeout {
+        t.Errorf("Timeout = %v, want default %v", client.Timeout, defaultRequestTimeout)
+    }
+}
+
+func TestNewHTTPClientMissingCaFile(t *testing.T) {
+    _, err := newHTTPClient(Node{CaFile: "/nonexistent/ca.pem"})
+    if err == nil {
+        t.Fatal("expected an error for a missing ca_file")
+    }
+}
+
+func TestNewHTTPClientMissingClientCert(t *testing.T) {
+    _, err := newHTTPClient(Node{ClientCertFile: "/nonexistent/cert.pem", ClientKeyFile: "/nonexistent/key.pem"})
+    if err == nil {
+        t.Fatal("expected an error for missing client cert/key files")
+    }
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// Exporter implements prometheus.Collector, fetching fresh data from the
+// RabbitMQ management API for every node inside Collect rather than relying
+// on a background polling loop. This ties data freshness to Prometheus's own
+// scrape_interval instead of a separately configured one.
+type Exporter struct {
+    nodes   []Node
+    modules moduleSet
+    clients map[string]*http.Client
+
+    // vhostFilter/queueFilter bound which per-object metrics get emitted for
+    // this Exporter's nodes. They live here rather than as package globals
+    // so a /probe Exporter can use its own cardinality rules instead of
+    // being held hostage by the primary config's.
+    vhostFilter objectFilter
+    queueFilter objectFilter
+
+    // m holds every domain metric, owned by this Exporter instance alone so
+    // that a throwaway /probe Exporter never shares state with the
+    // long-lived /metrics Exporter.
+    m *metrics
+
+    // scrapeMu serializes Collect so two overlapping scrapes (a second
+    // Prometheus replica, a retried scrape, a manual curl mid-scrape) can't
+    // interleave Reset()/Set() calls across each other's fetches and hand
+    // back a mix of two points in time.
+    scrapeMu sync.Mutex
+
+    up             *prometheus.GaugeVec
+    scrapeDuration prometheus.Gauge
+    scrapeFailures *prometheus.CounterVec
+}
+
+// NewExporter builds an Exporter that scrapes the given nodes on demand,
+// restricted to the given set of enabled modules. One *http.Client is built
+// per node up front and reused across scrapes instead of being allocated on
+// every API call.
+func NewExporter(nodes []Node, modules moduleSet, vhostFilter, queueFilter objectFilter) *Exporter {
+    clients := make(map[string]*http.Client, len(nodes))
+    for _, node := range nodes {
+        client, err := newHTTPClient(node)
+        if err != nil {
+            log.Errorf("error building http client for node %q: %v", node.Name, err)
+            client = http.DefaultClient
+        }
+        clients[node.Name] = client
+    }
+
+    return &Exporter{
+        nodes:       nodes,
+        modules:     modules,
+        clients:     clients,
+        vhostFilter: vhostFilter,
+        queueFilter: queueFilter,
+        m:           newMetrics(),
+        up: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "up",
+                Help:      "Was the last scrape of the RabbitMQ management API successful.",
+            },
+            []string{"node"},
+        ),
+        scrapeDuration: prometheus.NewGauge(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "scrape_duration_seconds",
+                Help:      "Time it took to scrape all configured nodes.",
+            },
+        ),
+        scrapeFailures: prometheus.NewCounterVec(
+            prometheus.CounterOpts{
+                Namespace: namespace,
+                Name:      "scrape_failures_total",
+                Help:      "Number of failed scrapes per node.",
+            },
+            []string{"node"},
+        ),
+    }
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+    e.up.Describe(ch)
+    e.scrapeDuration.Describe(ch)
+    e.scrapeFailures.Describe(ch)
+
+    for _, m := range e.m.all() {
+        m.Describe(ch)
+    }
+}
+
+// Collect implements prometheus.Collector. It fetches the RabbitMQ
+// management API for every configured node synchronously, one node after
+// the other, and only once all nodes are fetched forwards every metric to ch.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+    e.scrapeMu.Lock()
+    defer e.scrapeMu.Unlock()
+
+    start := time.Now()
+
+    e.m.resetPerObjectMetrics()
+    e.m.resetConnectionMetrics()
+    e.m.resetNodeMetrics()
+    e.m.resetFederationMetrics()
+
+    for _, node := range e.nodes {
+        err := e.collectNode(node)
+        if err != nil {
+            log.Errorf("error scraping node %q: %v", node.Name, err)
+            e.up.WithLabelValues(node.Name).Set(0)
+            e.scrapeFailures.WithLabelValues(node.Name).Inc()
+        } else {
+            e.up.WithLabelValues(node.Name).Set(1)
+        }
+    }
+
+    e.scrapeDuration.Set(time.Since(start).Seconds())
+
+    for _, m := range e.m.all() {
+        m.Collect(ch)
+    }
+    e.up.Collect(ch)
+    e.scrapeDuration.Collect(ch)
+    e.scrapeFailures.Collect(ch)
+}
+
+// collectNode runs every enabled fetch against a single node, returning the
+// first error encountered but still attempting the remaining fetches so a
+// single failing endpoint doesn't blank out everything else.
+func (e *Exporter) collectNode(node Node) error {
+    client := e.clients[node.Name]
+
+    var firstErr error
+    record := func(err error) {
+        if err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+
+    if e.modules.enabled(moduleOverview) {
+        record(getOverview(client, node, e.m))
+        // getQueuesStats already derives messagesTotal from the same
+        // /api/queues response when moduleQueues is also enabled, so only
+        // fall back to this independent fetch when it isn't.
+        if !e.modules.enabled(moduleQueues) {
+            record(getNumberOfMessages(client, node, e.m))
+        }
+    }
+    if e.modules.enabled(moduleQueues) {
+        record(getQueuesStats(client, node, e.vhostFilter, e.queueFilter, e.m))
+    }
+    if e.modules.enabled(moduleExchanges) {
+        record(getExchangesStats(client, node, e.vhostFilter, e.m))
+    }
+    if e.modules.enabled(moduleVhosts) {
+        record(getVhostsStats(client, node, e.vhostFilter, e.m))
+    }
+    if e.modules.enabled(moduleConnections) {
+        record(getConnectionsStats(client, node, e.m))
+    }
+    if e.modules.enabled(moduleNodes) {
+        record(getNodesStats(client, node, e.m))
+    }
+    if e.modules.enabled(moduleHealthchecks) {
+        record(getHealthchecks(client, node, e.m))
+    }
+    if e.modules.enabled(moduleFederation) {
+        record(getFederationLinksStats(client, node, e.m))
+        record(getShovelsStats(client, node, e.m))
+    }
+
+    return firstErr
+}
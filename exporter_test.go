@@ -0,0 +1,95 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func drainCollect(e *Exporter) {
+    ch := make(chan prometheus.Metric)
+    go func() {
+        e.Collect(ch)
+        close(ch)
+    }()
+    for range ch {
+    }
+}
+
+func TestExporterCollectPartialFailure(t *testing.T) {
+    good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/api/overview":
+            w.Write([]byte(`{"node": "rabbit@good", "object_totals": {}, "queue_totals": {}, "message_stats": {}}`))
+        case "/api/queues":
+            w.Write([]byte(`[]`))
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer good.Close()
+
+    nodes := []Node{
+        {Name: "good", Url: good.URL},
+        {Name: "bad", Url: "http://127.0.0.1:0"},
+    }
+    modules := newModuleSet([]string{moduleOverview, moduleQueues})
+    noFilter := compileFilter("", "")
+
+    e := NewExporter(nodes, modules, noFilter, noFilter)
+    drainCollect(e)
+
+    if got := testutil.ToFloat64(e.up.WithLabelValues("good")); got != 1 {
+        t.Errorf("up[good] = %v, want 1", got)
+    }
+    if got := testutil.ToFloat64(e.up.WithLabelValues("bad")); got != 0 {
+        t.Errorf("up[bad] = %v, want 0", got)
+    }
+    if got := testutil.ToFloat64(e.scrapeFailures.WithLabelValues("bad")); got != 1 {
+        t.Errorf("scrapeFailures[bad] = %v, want 1", got)
+    }
+    if got := testutil.ToFloat64(e.scrapeFailures.WithLabelValues("good")); got != 0 {
+        t.Errorf("scrapeFailures[good] = %v, want 0", got)
+    }
+    if testutil.ToFloat64(e.scrapeDuration) <= 0 {
+        t.Error("scrapeDuration should record a positive duration after a scrape")
+    }
+}
+
+func TestExporterCollectResetsBetweenScrapes(t *testing.T) {
+    queueCount := 2
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/api/overview":
+            w.Write([]byte(`{"node": "rabbit@node1", "object_totals": {}, "queue_totals": {}, "message_stats": {}}`))
+        case "/api/queues":
+            if queueCount == 2 {
+                w.Write([]byte(`[{"vhost": "/", "name": "a", "node": "rabbit@node1", "messages": 1}, {"vhost": "/", "name": "b", "node": "rabbit@node1", "messages": 1}]`))
+            } else {
+                w.Write([]byte(`[{"vhost": "/", "name": "a", "node": "rabbit@node1", "messages": 1}]`))
+            }
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer server.Close()
+
+    nodes := []Node{{Name: "node1", Url: server.URL}}
+    modules := newModuleSet([]string{moduleOverview, moduleQueues})
+    noFilter := compileFilter("", "")
+    e := NewExporter(nodes, modules, noFilter, noFilter)
+
+    drainCollect(e)
+    if testutil.ToFloat64(e.m.queueMessages.WithLabelValues("/", "b", "rabbit@node1")) != 1 {
+        t.Fatal("queue b should be present after the first scrape")
+    }
+
+    queueCount = 1
+    drainCollect(e)
+    if testutil.ToFloat64(e.m.queueMessages.WithLabelValues("/", "b", "rabbit@node1")) != 0 {
+        t.Error("queue b was removed from the broker but its stale label set still reports a value after a second scrape")
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+)
+
+// federationLinkStatuses and shovelStatuses are the complete sets of states
+// each link/shovel can report. Every known state gets its own gauge set to
+// 0/1 so operators can alert on a specific state without having to know
+// which other values the series can take.
+var (
+    federationLinkStatuses = []string{"starting", "running", "syncing", "terminated", "error"}
+    shovelStatuses         = []string{"starting", "running", "terminated", "error"}
+)
+
+func getFederationLinksStats(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/federation-links")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    for _, link := range response {
+        vhost, _ := link["vhost"].(string)
+        upstream, _ := link["upstream"].(string)
+        queue, _ := link["queue"].(string)
+        exchange, _ := link["exchange"].(string)
+        status, _ := link["status"].(string)
+        uri, _ := link["uri"].(string)
+        upstreamURI := stripCredentials(uri)
+
+        for _, candidate := range federationLinkStatuses {
+            value := 0.0
+            if candidate == status {
+                value = 1.0
+            }
+            m.federationLinkStatus.WithLabelValues(vhost, upstream, upstreamURI, queue, exchange, candidate).Set(value)
+        }
+    }
+    return nil
+}
+
+func getShovelsStats(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/shovels")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    for _, shovel := range response {
+        vhost, _ := shovel["vhost"].(string)
+        name, _ := shovel["name"].(string)
+        state, _ := shovel["state"].(string)
+
+        for _, candidate := range shovelStatuses {
+            value := 0.0
+            if candidate == state {
+                value = 1.0
+            }
+            m.shovelStatus.WithLabelValues(vhost, name, candidate).Set(value)
+        }
+    }
+    return nil
+}
+
+// stripCredentials removes userinfo from a URI so upstream connection
+// strings can be surfaced as a label without leaking passwords.
+func stripCredentials(uri string) string {
+    if uri == "" {
+        return ""
+    }
+    parsed, err := url.Parse(uri)
+    if err != nil {
+        return ""
+    }
+    parsed.User = nil
+    return parsed.String()
+}
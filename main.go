@@ -1,10 +1,12 @@
 package main
 
 import (
+    "bytes"
     "encoding/json"
     "io/ioutil"
     "net/http"
     "os"
+    "strings"
     "time"
 
     "github.com/Sirupsen/logrus"
@@ -12,191 +14,45 @@ import (
 )
 
 const (
-    namespace  = "rabbitmq"
-    defaultConfigPath = "config.json"
+    namespace           = "rabbitmq"
+    defaultConfigPath   = "config.json"
+    defaultExporterPort = "9090"
 )
 
 var log = logrus.New()
 
-// Listed available metrics
-var (
-    connectionsTotal = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Namespace: namespace,
-            Name:      "connections_total",
-            Help:      "Total number of open connections.",
-        },
-        []string{
-            // Which node was checked?
-            "node",
-        },
-    )
-    channelsTotal = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Namespace: namespace,
-            Name:      "channels_total",
-            Help:      "Total number of open channels.",
-        },
-        []string{
-            "node",
-        },
-    )
-    queuesTotal = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Namespace: namespace,
-            Name:      "queues_total",
-            Help:      "Total number of queues in use.",
-        },
-        []string{
-            "node",
-        },
-    )
-    consumersTotal = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Namespace: namespace,
-            Name:      "consumers_total",
-            Help:      "Total number of message consumers.",
-        },
-        []string{
-            "node",
-        },
-    )
-    exchangesTotal = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Namespace: namespace,
-            Name:      "exchanges_total",
-            Help:      "Total number of exchanges in use.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messagesTotal = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Namespace: namespace,
-            Name:      "messages_total",
-            Help:      "Total number of messages in all queues.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messagesCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "messages",
-            Name:      "messages",
-            Help:      "Counter of messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messagesReadyCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "messages",
-            Name:      "messages_ready",
-            Help:      "Counter of ready messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messagesUnacknowledgedCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "messages",
-            Name:      "messages_unacknowledged",
-            Help:      "Counter of unacknowledged messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsPublishCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_published",
-            Help:      "Counter of published messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsAckCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_acked",
-            Help:      "Counter of acked messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsDeliverCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_delivered",
-            Help:      "Counter of delivered messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsConfirmCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_confirmed",
-            Help:      "Counter of confirmed messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsRedeliverCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_redelivered",
-            Help:      "Counter of redelivered messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsDeliverGetCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_delivered_get",
-            Help:      "Counter of delivered get messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-    messageStatsDeliverNoAckCounter = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: "message_stats",
-            Name:      "messages_delivered_no_ack",
-            Help:      "Counter of delivered no ack messages.",
-        },
-        []string{
-            "node",
-        },
-    )
-)
-
 type Config struct {
-    Nodes    *[]Node `json:"nodes"`
-    Port     string  `json:"port"`
-    Interval string  `json:"req_interval"`
+    Nodes *[]Node `json:"nodes"`
+    Port  string  `json:"port"`
+
+    // IncludeVHost/SkipVHost and IncludeQueues/SkipQueues bound the
+    // cardinality of the per-object metrics (see queues.go) by regexp. An empty
+    // include matches everything; skip always takes precedence.
+    IncludeVHost  string `json:"include_vhost,omitempty"`
+    SkipVHost     string `json:"skip_vhost,omitempty"`
+    IncludeQueues string `json:"include_queues,omitempty"`
+    SkipQueues    string `json:"skip_queues,omitempty"`
+
+    // Modules lists which API endpoints to scrape (see modules.go). An empty
+    // list falls back to defaultModules.
+    Modules []string `json:"modules,omitempty"`
+
+    // ProbeUsername/ProbePassword/ProbeBearerToken are the credentials every
+    // /probe request authenticates with. They are never accepted as request
+    // parameters - see probeHandler.
+    ProbeUsername    string `json:"probe_username,omitempty"`
+    ProbePassword    string `json:"probe_password,omitempty"`
+    ProbeBearerToken string `json:"probe_bearer_token,omitempty"`
+
+    // ProbeCaFile/ProbeClientCertFile/ProbeClientKeyFile are the TLS
+    // material every /probe request authenticates with. Like the
+    // credentials above, these are never accepted as request parameters:
+    // a caller who controls both the target URL and an arbitrary local
+    // file path could make the exporter load any key on the host and
+    // present it to a server of their choosing.
+    ProbeCaFile         string `json:"probe_ca_file,omitempty"`
+    ProbeClientCertFile string `json:"probe_client_cert_file,omitempty"`
+    ProbeClientKeyFile  string `json:"probe_client_key_file,omitempty"`
 }
 
 type Node struct {
@@ -204,130 +60,136 @@ type Node struct {
     Url      string `json:"url"`
     Uname    string `json:"uname"`
     Password string `json:"password"`
-    Interval string `json:"req_interval,omitempty"`
+
+    // BearerToken, when set, is sent instead of basic auth - for
+    // OAuth2-protected management APIs.
+    BearerToken string `json:"bearer_token,omitempty"`
+
+    // ManagementPathPrefix is prepended to every API path, for management
+    // plugins reverse-proxied under a subpath (e.g. "/rabbitmq").
+    ManagementPathPrefix string `json:"management_path_prefix,omitempty"`
+
+    // Timeout bounds how long a single API request may take. Defaults to
+    // defaultRequestTimeout when empty or unparsable.
+    Timeout string `json:"timeout,omitempty"`
+
+    // TLS options for talking to a management API over https.
+    InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+    CaFile             string `json:"ca_file,omitempty"`
+    ClientCertFile     string `json:"client_cert_file,omitempty"`
+    ClientKeyFile      string `json:"client_key_file,omitempty"`
 }
 
-func sendApiRequest(hostname, username, password, query string) *json.Decoder {
-    client := &http.Client{}
-    req, err := http.NewRequest("GET", hostname+query, nil)
-    req.SetBasicAuth(username, password)
+func sendApiRequest(client *http.Client, node Node, query string) (*json.Decoder, error) {
+    req, err := http.NewRequest("GET", node.Url+node.ManagementPathPrefix+query, nil)
+    if err != nil {
+        return nil, err
+    }
+    if node.BearerToken != "" {
+        req.Header.Set("Authorization", "Bearer "+node.BearerToken)
+    } else {
+        req.SetBasicAuth(node.Uname, node.Password)
+    }
 
     resp, err := client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
 
+    // Read the body fully (and close it above) so the connection is
+    // returned to client's keep-alive pool instead of leaking a socket
+    // per scrape.
+    body, err := ioutil.ReadAll(resp.Body)
     if err != nil {
-        log.Error(err)
-        panic(err)
+        return nil, err
     }
-    return json.NewDecoder(resp.Body)
+    return json.NewDecoder(bytes.NewReader(body)), nil
 }
 
-func getOverview(hostname, username, password string) {
-    decoder := sendApiRequest(hostname, username, password, "/api/overview")
-    response := decodeObj(decoder)
+func getOverview(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/overview")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObj(decoder)
+    if err != nil {
+        return err
+    }
 
     objectTotals := make(map[string]float64)
-    for k, v := range response["object_totals"].(map[string]interface{}) {
-        objectTotals[k] = v.(float64)
+    if raw, ok := response["object_totals"].(map[string]interface{}); ok {
+        for k, v := range raw {
+            objectTotals[k] = floatValue(v)
+        }
     }
 
     queueTotals := make(map[string]float64)
-    for k, v := range response["queue_totals"].(map[string]interface{}) {
-        switch v.(type) {
-        case float64:
-            queueTotals[k] = v.(float64)
+    if raw, ok := response["queue_totals"].(map[string]interface{}); ok {
+        for k, v := range raw {
+            queueTotals[k] = floatValue(v)
         }
     }
 
     messageStats := make(map[string]float64)
-    for k, v := range response["message_stats"].(map[string]interface{}) {
-        switch v.(type) {
-        case float64:
-            messageStats[k] = v.(float64)
+    if raw, ok := response["message_stats"].(map[string]interface{}); ok {
+        for k, v := range raw {
+            messageStats[k] = floatValue(v)
         }
     }
 
     nodename, _ := response["node"].(string)
 
-    channelsTotal.WithLabelValues(nodename).Set(objectTotals["channels"])
-    connectionsTotal.WithLabelValues(nodename).Set(objectTotals["connections"])
-    consumersTotal.WithLabelValues(nodename).Set(objectTotals["consumers"])
-    queuesTotal.WithLabelValues(nodename).Set(objectTotals["queues"])
-    exchangesTotal.WithLabelValues(nodename).Set(objectTotals["exchanges"])
-    messagesCounter.WithLabelValues(nodename).Set(queueTotals["messages"])
-    messagesReadyCounter.WithLabelValues(nodename).Set(queueTotals["messages_ready"])
-    messagesUnacknowledgedCounter.WithLabelValues(nodename).Set(queueTotals["messages_unacknowledged"])
-    messageStatsRedeliverCounter.WithLabelValues(nodename).Set(messageStats["redeliver"])
-    messageStatsConfirmCounter.WithLabelValues(nodename).Set(messageStats["confirm"])
-    messageStatsDeliverCounter.WithLabelValues(nodename).Set(messageStats["deliver"])
-    messageStatsPublishCounter.WithLabelValues(nodename).Set(messageStats["publish"])
-    messageStatsAckCounter.WithLabelValues(nodename).Set(messageStats["ack"])
-    messageStatsDeliverGetCounter.WithLabelValues(nodename).Set(messageStats["deliver_get"])
-    messageStatsDeliverNoAckCounter.WithLabelValues(nodename).Set(messageStats["deliver_no_ack"])
+    m.channelsTotal.WithLabelValues(nodename).Set(objectTotals["channels"])
+    m.connectionsTotal.WithLabelValues(nodename).Set(objectTotals["connections"])
+    m.consumersTotal.WithLabelValues(nodename).Set(objectTotals["consumers"])
+    m.queuesTotal.WithLabelValues(nodename).Set(objectTotals["queues"])
+    m.exchangesTotal.WithLabelValues(nodename).Set(objectTotals["exchanges"])
+    m.messagesGauge.WithLabelValues(nodename).Set(queueTotals["messages"])
+    m.messagesReadyGauge.WithLabelValues(nodename).Set(queueTotals["messages_ready"])
+    m.messagesUnacknowledgedGauge.WithLabelValues(nodename).Set(queueTotals["messages_unacknowledged"])
+    m.messageStatsRedeliverGauge.WithLabelValues(nodename).Set(messageStats["redeliver"])
+    m.messageStatsConfirmGauge.WithLabelValues(nodename).Set(messageStats["confirm"])
+    m.messageStatsDeliverGauge.WithLabelValues(nodename).Set(messageStats["deliver"])
+    m.messageStatsPublishGauge.WithLabelValues(nodename).Set(messageStats["publish"])
+    m.messageStatsAckGauge.WithLabelValues(nodename).Set(messageStats["ack"])
+    m.messageStatsDeliverGetGauge.WithLabelValues(nodename).Set(messageStats["deliver_get"])
+    m.messageStatsDeliverNoAckGauge.WithLabelValues(nodename).Set(messageStats["deliver_no_ack"])
+    return nil
 }
 
-func getNumberOfMessages(hostname, username, password string) {
-    decoder := sendApiRequest(hostname, username, password, "/api/queues")
-    response := decodeObjArray(decoder)
-    nodename := response[0]["node"].(string)
+func getNumberOfMessages(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/queues")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+    if len(response) == 0 {
+        return nil
+    }
+    nodename, _ := response[0]["node"].(string)
 
     total_messages := 0.0
     for _, v := range response {
-        total_messages += v["messages"].(float64)
+        total_messages += floatValue(v["messages"])
     }
-    messagesTotal.WithLabelValues(nodename).Set(total_messages)
+    m.messagesTotal.WithLabelValues(nodename).Set(total_messages)
+    return nil
 }
 
-func decodeObj(d *json.Decoder) map[string]interface{} {
+func decodeObj(d *json.Decoder) (map[string]interface{}, error) {
     var response map[string]interface{}
-
-    if err := d.Decode(&response); err != nil {
-        log.Error(err)
-    }
-    return response
+    err := d.Decode(&response)
+    return response, err
 }
 
-func decodeObjArray(d *json.Decoder) []map[string]interface{} {
+func decodeObjArray(d *json.Decoder) ([]map[string]interface{}, error) {
     var response []map[string]interface{}
-
-    if err := d.Decode(&response); err != nil {
-        log.Error(err)
-    }
-    return response
-}
-
-func updateNodesStats(config *Config) {
-    for _, node := range *config.Nodes {
-
-        if len(node.Interval) == 0 {
-            node.Interval = config.Interval
-        }
-        go runRequestLoop(node)
-    }
-}
-
-func requestData(node Node) {
-    defer func() {
-        if r := recover(); r != nil {
-            dt := 10 * time.Second
-            time.Sleep(dt)
-        }
-    }()
-
-    getOverview(node.Url, node.Uname, node.Password)
-    getNumberOfMessages(node.Url, node.Uname, node.Password)
-
-    dt, err := time.ParseDuration(node.Interval)
-    if err != nil {
-        log.Warn(err)
-        dt = 30 * time.Second
-    }
-    time.Sleep(dt)
-}
-
-func runRequestLoop(node Node) {
-    for {
-        requestData(node)
-    }
+    err := d.Decode(&response)
+    return response, err
 }
 
 func loadConfig(path string, c *Config) bool {
@@ -361,6 +223,30 @@ func runLoadConfigLoop(path string, c *Config) {
     }
 }
 
+// loadConfigFromEnv builds a Config for a single node from RABBIT_URL,
+// RABBIT_USER, RABBIT_PASSWORD and RABBIT_CAPABILITIES, letting the exporter
+// run without a config.json. It reports whether RABBIT_URL was set at all.
+func loadConfigFromEnv(c *Config) bool {
+    url := os.Getenv("RABBIT_URL")
+    if url == "" {
+        return false
+    }
+
+    node := Node{
+        Name:     url,
+        Url:      url,
+        Uname:    os.Getenv("RABBIT_USER"),
+        Password: os.Getenv("RABBIT_PASSWORD"),
+    }
+
+    c.Nodes = &[]Node{node}
+    c.Port = defaultExporterPort
+    if capabilities := os.Getenv("RABBIT_CAPABILITIES"); capabilities != "" {
+        c.Modules = strings.Split(capabilities, ",")
+    }
+    return true
+}
+
 func main() {
     configPath := defaultConfigPath
     if len(os.Args) > 1 {
@@ -370,10 +256,18 @@ func main() {
 
     var config Config
 
-    runLoadConfigLoop(configPath, &config)
-    updateNodesStats(&config)
+    if !loadConfigFromEnv(&config) {
+        runLoadConfigLoop(configPath, &config)
+    }
+
+    vhostFilter := compileFilter(config.IncludeVHost, config.SkipVHost)
+    queueFilter := compileFilter(config.IncludeQueues, config.SkipQueues)
+
+    exporter := NewExporter(*config.Nodes, newModuleSet(config.Modules), vhostFilter, queueFilter)
+    prometheus.MustRegister(exporter)
 
     http.Handle("/metrics", prometheus.Handler())
+    http.HandleFunc("/probe", probeHandler(&config))
     http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
         w.Write([]byte(`<html>
              <head><title>RabbitMQ Exporter</title></head>
@@ -386,22 +280,3 @@ func main() {
     log.Infof("Starting RabbitMQ exporter on port: %s.", config.Port)
     http.ListenAndServe(":"+config.Port, nil)
 }
-
-// Register metrics to Prometheus
-func init() {
-    prometheus.MustRegister(channelsTotal)
-    prometheus.MustRegister(connectionsTotal)
-    prometheus.MustRegister(queuesTotal)
-    prometheus.MustRegister(exchangesTotal)
-    prometheus.MustRegister(consumersTotal)
-    prometheus.MustRegister(messagesTotal)
-    prometheus.MustRegister(messagesCounter)
-    prometheus.MustRegister(messagesReadyCounter)
-    prometheus.MustRegister(messageStatsAckCounter)
-    prometheus.MustRegister(messageStatsDeliverCounter)
-    prometheus.MustRegister(messageStatsRedeliverCounter)
-    prometheus.MustRegister(messageStatsConfirmCounter)
-    prometheus.MustRegister(messageStatsPublishCounter)
-    prometheus.MustRegister(messageStatsDeliverNoAckCounter)
-    prometheus.MustRegister(messageStatsDeliverGetCounter)
-}
@@ -0,0 +1,571 @@
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// metrics holds every domain GaugeVec the exporter populates.
+// Each Exporter owns its own *metrics instance (see NewExporter) instead of
+// these living as package-level vars, so a throwaway /probe Exporter can't
+// race with - and corrupt - the long-lived /metrics Exporter's data.
+type metrics struct {
+    connectionsTotal *prometheus.GaugeVec
+    channelsTotal    *prometheus.GaugeVec
+    queuesTotal      *prometheus.GaugeVec
+    consumersTotal   *prometheus.GaugeVec
+    exchangesTotal   *prometheus.GaugeVec
+    messagesTotal    *prometheus.GaugeVec
+
+    messagesGauge                 *prometheus.GaugeVec
+    messagesReadyGauge            *prometheus.GaugeVec
+    messagesUnacknowledgedGauge   *prometheus.GaugeVec
+    messageStatsPublishGauge      *prometheus.GaugeVec
+    messageStatsAckGauge          *prometheus.GaugeVec
+    messageStatsDeliverGauge      *prometheus.GaugeVec
+    messageStatsConfirmGauge      *prometheus.GaugeVec
+    messageStatsRedeliverGauge    *prometheus.GaugeVec
+    messageStatsDeliverGetGauge   *prometheus.GaugeVec
+    messageStatsDeliverNoAckGauge *prometheus.GaugeVec
+
+    queueMessages               *prometheus.GaugeVec
+    queueMessagesReady          *prometheus.GaugeVec
+    queueMessagesUnacknowledged *prometheus.GaugeVec
+    queueConsumers              *prometheus.GaugeVec
+    queueMemory                 *prometheus.GaugeVec
+    queueMessageBytes           *prometheus.GaugeVec
+
+    queueMessagesPublishedTotal   *prometheus.GaugeVec
+    queueMessagesDeliveredTotal   *prometheus.GaugeVec
+    queueMessagesAckedTotal       *prometheus.GaugeVec
+    queueMessagesRedeliveredTotal *prometheus.GaugeVec
+
+    exchangeMessagesPublishedTotal    *prometheus.GaugeVec
+    exchangeMessagesPublishedInTotal  *prometheus.GaugeVec
+    exchangeMessagesPublishedOutTotal *prometheus.GaugeVec
+
+    vhostMessages               *prometheus.GaugeVec
+    vhostMessagesReady          *prometheus.GaugeVec
+    vhostMessagesUnacknowledged *prometheus.GaugeVec
+
+    connectionsByState *prometheus.GaugeVec
+
+    nodeRunning      *prometheus.GaugeVec
+    nodeMemUsed      *prometheus.GaugeVec
+    nodeMemLimit     *prometheus.GaugeVec
+    nodeDiskFree     *prometheus.GaugeVec
+    nodeFdUsed       *prometheus.GaugeVec
+    nodeFdTotal      *prometheus.GaugeVec
+    nodeSocketsUsed  *prometheus.GaugeVec
+    nodeSocketsTotal *prometheus.GaugeVec
+
+    healthcheckOk *prometheus.GaugeVec
+
+    federationLinkStatus *prometheus.GaugeVec
+    shovelStatus         *prometheus.GaugeVec
+}
+
+// newMetrics builds a fresh, unregistered set of every domain metric.
+func newMetrics() *metrics {
+    return &metrics{
+        connectionsTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "connections_total",
+                Help:      "Total number of open connections.",
+            },
+            []string{"node"},
+        ),
+        channelsTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "channels_total",
+                Help:      "Total number of open channels.",
+            },
+            []string{"node"},
+        ),
+        queuesTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "queues_total",
+                Help:      "Total number of queues in use.",
+            },
+            []string{"node"},
+        ),
+        consumersTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "consumers_total",
+                Help:      "Total number of message consumers.",
+            },
+            []string{"node"},
+        ),
+        exchangesTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "exchanges_total",
+                Help:      "Total number of exchanges in use.",
+            },
+            []string{"node"},
+        ),
+        messagesTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Name:      "messages_total",
+                Help:      "Total number of messages in all queues.",
+            },
+            []string{"node"},
+        ),
+        messagesGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "messages",
+                Name:      "messages",
+                Help:      "Number of messages.",
+            },
+            []string{"node"},
+        ),
+        messagesReadyGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "messages",
+                Name:      "messages_ready",
+                Help:      "Number of ready messages.",
+            },
+            []string{"node"},
+        ),
+        messagesUnacknowledgedGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "messages",
+                Name:      "messages_unacknowledged",
+                Help:      "Number of unacknowledged messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsPublishGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_published",
+                Help:      "Number of published messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsAckGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_acked",
+                Help:      "Number of acked messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsDeliverGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_delivered",
+                Help:      "Number of delivered messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsConfirmGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_confirmed",
+                Help:      "Number of confirmed messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsRedeliverGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_redelivered",
+                Help:      "Number of redelivered messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsDeliverGetGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_delivered_get",
+                Help:      "Number of delivered get messages.",
+            },
+            []string{"node"},
+        ),
+        messageStatsDeliverNoAckGauge: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "message_stats",
+                Name:      "messages_delivered_no_ack",
+                Help:      "Number of delivered no ack messages.",
+            },
+            []string{"node"},
+        ),
+
+        queueMessages: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages",
+                Help:      "Number of messages in the queue, ready plus unacknowledged.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMessagesReady: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages_ready",
+                Help:      "Number of messages ready to be delivered to consumers.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMessagesUnacknowledged: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages_unacknowledged",
+                Help:      "Number of messages delivered to consumers but not yet acknowledged.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueConsumers: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "consumers",
+                Help:      "Number of consumers attached to the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMemory: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "memory_bytes",
+                Help:      "Bytes of memory allocated by the runtime for the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMessageBytes: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "message_bytes",
+                Help:      "Sum of the size of all message bodies in the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+
+        queueMessagesPublishedTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages_published_total",
+                Help:      "Count of messages published into the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMessagesDeliveredTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages_delivered_total",
+                Help:      "Count of messages delivered to consumers from the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMessagesAckedTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages_acked_total",
+                Help:      "Count of messages acked by consumers from the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+        queueMessagesRedeliveredTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "queue",
+                Name:      "messages_redelivered_total",
+                Help:      "Count of messages redelivered from the queue.",
+            },
+            []string{"vhost", "queue", "node"},
+        ),
+
+        exchangeMessagesPublishedTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "exchange",
+                Name:      "messages_published_total",
+                Help:      "Count of messages published to the exchange.",
+            },
+            []string{"vhost", "exchange", "type", "node"},
+        ),
+        exchangeMessagesPublishedInTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "exchange",
+                Name:      "messages_published_in_total",
+                Help:      "Count of messages published into the exchange, from channels.",
+            },
+            []string{"vhost", "exchange", "type", "node"},
+        ),
+        exchangeMessagesPublishedOutTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "exchange",
+                Name:      "messages_published_out_total",
+                Help:      "Count of messages published out of the exchange, to queues or other exchanges.",
+            },
+            []string{"vhost", "exchange", "type", "node"},
+        ),
+
+        vhostMessages: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "vhost",
+                Name:      "messages",
+                Help:      "Number of messages in the vhost, ready plus unacknowledged.",
+            },
+            []string{"vhost"},
+        ),
+        vhostMessagesReady: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "vhost",
+                Name:      "messages_ready",
+                Help:      "Number of messages ready to be delivered to consumers in the vhost.",
+            },
+            []string{"vhost"},
+        ),
+        vhostMessagesUnacknowledged: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "vhost",
+                Name:      "messages_unacknowledged",
+                Help:      "Number of messages delivered to consumers but not yet acknowledged in the vhost.",
+            },
+            []string{"vhost"},
+        ),
+
+        connectionsByState: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "connections",
+                Name:      "state",
+                Help:      "Number of connections in a given state.",
+            },
+            []string{"vhost", "state", "node"},
+        ),
+
+        nodeRunning: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "running",
+                Help:      "Whether the node is running, as reported by the cluster.",
+            },
+            []string{"node"},
+        ),
+        nodeMemUsed: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "mem_used_bytes",
+                Help:      "Memory used by the node's Erlang process, in bytes.",
+            },
+            []string{"node"},
+        ),
+        nodeMemLimit: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "mem_limit_bytes",
+                Help:      "Point at which the memory alarm will go off for the node.",
+            },
+            []string{"node"},
+        ),
+        nodeDiskFree: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "disk_free_bytes",
+                Help:      "Free disk space on the node, in bytes.",
+            },
+            []string{"node"},
+        ),
+        nodeFdUsed: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "fd_used",
+                Help:      "File descriptors used by the node.",
+            },
+            []string{"node"},
+        ),
+        nodeFdTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "fd_total",
+                Help:      "File descriptors available to the node.",
+            },
+            []string{"node"},
+        ),
+        nodeSocketsUsed: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "sockets_used",
+                Help:      "Sockets used by the node.",
+            },
+            []string{"node"},
+        ),
+        nodeSocketsTotal: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "node",
+                Name:      "sockets_total",
+                Help:      "Sockets available to the node.",
+            },
+            []string{"node"},
+        ),
+
+        healthcheckOk: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "healthcheck",
+                Name:      "ok",
+                Help:      "Result of the node's /api/healthchecks/node check, 1 for ok.",
+            },
+            []string{"node"},
+        ),
+
+        federationLinkStatus: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "federation",
+                Name:      "link_status",
+                Help:      "State of a federation link, 1 for the link's current status and 0 for every other possible status.",
+            },
+            []string{"vhost", "upstream", "upstream_uri", "queue", "exchange", "status"},
+        ),
+        shovelStatus: prometheus.NewGaugeVec(
+            prometheus.GaugeOpts{
+                Namespace: namespace,
+                Subsystem: "shovel",
+                Name:      "status",
+                Help:      "State of a shovel, 1 for the shovel's current state and 0 for every other possible state.",
+            },
+            []string{"vhost", "shovel", "state"},
+        ),
+    }
+}
+
+// all lists every metric, so Describe/Collect can forward them without the
+// fetch functions needing direct access to the registry.
+func (m *metrics) all() []interface {
+    Describe(chan<- *prometheus.Desc)
+    Collect(chan<- prometheus.Metric)
+} {
+    return []interface {
+        Describe(chan<- *prometheus.Desc)
+        Collect(chan<- prometheus.Metric)
+    }{
+        m.channelsTotal,
+        m.connectionsTotal,
+        m.queuesTotal,
+        m.exchangesTotal,
+        m.consumersTotal,
+        m.messagesTotal,
+        m.messagesGauge,
+        m.messagesReadyGauge,
+        m.messagesUnacknowledgedGauge,
+        m.messageStatsAckGauge,
+        m.messageStatsDeliverGauge,
+        m.messageStatsRedeliverGauge,
+        m.messageStatsConfirmGauge,
+        m.messageStatsPublishGauge,
+        m.messageStatsDeliverNoAckGauge,
+        m.messageStatsDeliverGetGauge,
+        m.queueMessages,
+        m.queueMessagesReady,
+        m.queueMessagesUnacknowledged,
+        m.queueConsumers,
+        m.queueMemory,
+        m.queueMessageBytes,
+        m.queueMessagesPublishedTotal,
+        m.queueMessagesDeliveredTotal,
+        m.queueMessagesAckedTotal,
+        m.queueMessagesRedeliveredTotal,
+        m.exchangeMessagesPublishedTotal,
+        m.exchangeMessagesPublishedInTotal,
+        m.exchangeMessagesPublishedOutTotal,
+        m.vhostMessages,
+        m.vhostMessagesReady,
+        m.vhostMessagesUnacknowledged,
+        m.connectionsByState,
+        m.nodeRunning,
+        m.nodeMemUsed,
+        m.nodeMemLimit,
+        m.nodeDiskFree,
+        m.nodeFdUsed,
+        m.nodeFdTotal,
+        m.nodeSocketsUsed,
+        m.nodeSocketsTotal,
+        m.healthcheckOk,
+        m.federationLinkStatus,
+        m.shovelStatus,
+    }
+}
+
+// resetPerObjectMetrics clears every queue/exchange/vhost label set. It must
+// be called once at the start of a scrape cycle, before any node is fetched,
+// so that objects deleted since the previous scrape don't linger as stale
+// series.
+func (m *metrics) resetPerObjectMetrics() {
+    m.queueMessages.Reset()
+    m.queueMessagesReady.Reset()
+    m.queueMessagesUnacknowledged.Reset()
+    m.queueConsumers.Reset()
+    m.queueMemory.Reset()
+    m.queueMessageBytes.Reset()
+    m.queueMessagesPublishedTotal.Reset()
+    m.queueMessagesDeliveredTotal.Reset()
+    m.queueMessagesAckedTotal.Reset()
+    m.queueMessagesRedeliveredTotal.Reset()
+    m.exchangeMessagesPublishedTotal.Reset()
+    m.exchangeMessagesPublishedInTotal.Reset()
+    m.exchangeMessagesPublishedOutTotal.Reset()
+    m.vhostMessages.Reset()
+    m.vhostMessagesReady.Reset()
+    m.vhostMessagesUnacknowledged.Reset()
+}
+
+func (m *metrics) resetConnectionMetrics() {
+    m.connectionsByState.Reset()
+}
+
+// resetNodeMetrics clears every node/healthcheck label set, the same way
+// resetConnectionMetrics does for getConnectionsStats, so a node removed or
+// renamed in the cluster doesn't leave a permanently stale series behind.
+func (m *metrics) resetNodeMetrics() {
+    m.nodeRunning.Reset()
+    m.nodeMemUsed.Reset()
+    m.nodeMemLimit.Reset()
+    m.nodeDiskFree.Reset()
+    m.nodeFdUsed.Reset()
+    m.nodeFdTotal.Reset()
+    m.nodeSocketsUsed.Reset()
+    m.nodeSocketsTotal.Reset()
+    m.healthcheckOk.Reset()
+}
+
+func (m *metrics) resetFederationMetrics() {
+    m.federationLinkStatus.Reset()
+    m.shovelStatus.Reset()
+}
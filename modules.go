@@ -0,0 +1,43 @@
+package main
+
+// Modules gate which RabbitMQ management API endpoints get scraped, the way
+// the mikrotik and Traefik exporters let users disable expensive collectors
+// on large clusters.
+const (
+    moduleOverview     = "overview"
+    moduleQueues       = "queues"
+    moduleExchanges    = "exchanges"
+    moduleVhosts       = "vhosts"
+    moduleConnections  = "connections"
+    moduleNodes        = "nodes"
+    moduleHealthchecks = "healthchecks"
+    moduleFederation   = "federation"
+)
+
+// defaultModules mirrors what this exporter scraped before modules existed.
+// connections and nodes are opt-in, like healthchecks and federation,
+// since they're new endpoints this module system introduced rather than
+// something upgrading users were already paying for.
+var defaultModules = []string{
+    moduleOverview,
+    moduleQueues,
+    moduleExchanges,
+    moduleVhosts,
+}
+
+type moduleSet map[string]bool
+
+func newModuleSet(names []string) moduleSet {
+    if len(names) == 0 {
+        names = defaultModules
+    }
+    set := make(moduleSet, len(names))
+    for _, name := range names {
+        set[name] = true
+    }
+    return set
+}
+
+func (m moduleSet) enabled(name string) bool {
+    return m[name]
+}
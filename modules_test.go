@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestNewModuleSetDefaults(t *testing.T) {
+    set := newModuleSet(nil)
+
+    for _, m := range defaultModules {
+        if !set.enabled(m) {
+            t.Errorf("default module %q should be enabled", m)
+        }
+    }
+
+    for _, m := range []string{moduleConnections, moduleNodes, moduleHealthchecks, moduleFederation} {
+        if set.enabled(m) {
+            t.Errorf("opt-in module %q should not be enabled by default", m)
+        }
+    }
+}
+
+func TestNewModuleSetExplicit(t *testing.T) {
+    set := newModuleSet([]string{moduleOverview, moduleNodes})
+
+    if !set.enabled(moduleOverview) || !set.enabled(moduleNodes) {
+        t.Error("explicitly listed modules should be enabled")
+    }
+    if set.enabled(moduleQueues) {
+        t.Error("modules not in the explicit list should be disabled")
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "net/http"
+)
+
+func getConnectionsStats(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/connections")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    counts := make(map[[3]string]float64)
+    for _, c := range response {
+        vhost, _ := c["vhost"].(string)
+        state, _ := c["state"].(string)
+        brokerNode, _ := c["node"].(string)
+        counts[[3]string{vhost, state, brokerNode}]++
+    }
+    for key, count := range counts {
+        m.connectionsByState.WithLabelValues(key[0], key[1], key[2]).Set(count)
+    }
+    return nil
+}
+
+func getNodesStats(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/nodes")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    for _, n := range response {
+        name, _ := n["name"].(string)
+
+        running := 0.0
+        if isRunning, _ := n["running"].(bool); isRunning {
+            running = 1.0
+        }
+
+        m.nodeRunning.WithLabelValues(name).Set(running)
+        m.nodeMemUsed.WithLabelValues(name).Set(floatValue(n["mem_used"]))
+        m.nodeMemLimit.WithLabelValues(name).Set(floatValue(n["mem_limit"]))
+        m.nodeDiskFree.WithLabelValues(name).Set(floatValue(n["disk_free"]))
+        m.nodeFdUsed.WithLabelValues(name).Set(floatValue(n["fd_used"]))
+        m.nodeFdTotal.WithLabelValues(name).Set(floatValue(n["fd_total"]))
+        m.nodeSocketsUsed.WithLabelValues(name).Set(floatValue(n["sockets_used"]))
+        m.nodeSocketsTotal.WithLabelValues(name).Set(floatValue(n["sockets_total"]))
+    }
+    return nil
+}
+
+func getHealthchecks(client *http.Client, node Node, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/healthchecks/node")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObj(decoder)
+    if err != nil {
+        return err
+    }
+
+    ok := 0.0
+    if status, _ := response["status"].(string); status == "ok" {
+        ok = 1.0
+    }
+    m.healthcheckOk.WithLabelValues(node.Name).Set(ok)
+    return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "encoding/base64"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestProbeHandlerMissingTarget(t *testing.T) {
+    req := httptest.NewRequest("GET", "/probe", nil)
+    w := httptest.NewRecorder()
+
+    probeHandler(&Config{}).ServeHTTP(w, req)
+
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+    }
+}
+
+func TestProbeHandlerUsesServerSideCredentials(t *testing.T) {
+    var gotAuth string
+    target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        gotAuth = r.Header.Get("Authorization")
+        switch r.URL.Path {
+        case "/api/overview":
+            w.Write([]byte(`{"node": "rabbit@node1", "object_totals": {}, "queue_totals": {}, "message_stats": {}}`))
+        case "/api/queues":
+            w.Write([]byte(`[]`))
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer target.Close()
+
+    config := &Config{ProbeUsername: "configured-user", ProbePassword: "configured-pass"}
+
+    // A caller also supplies username/password/ca_file query params - these
+    // must never override the server-side config, since the target is
+    // equally attacker-controlled.
+    req := httptest.NewRequest("GET", "/probe?target="+target.URL+
+        "&module=overview,queues&username=attacker&password=attacker&ca_file=/etc/passwd", nil)
+    w := httptest.NewRecorder()
+
+    probeHandler(config).ServeHTTP(w, req)
+
+    if w.Code != http.StatusOK {
+        t.Fatalf("status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+    }
+
+    wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("configured-user:configured-pass"))
+    if gotAuth != wantAuth {
+        t.Errorf("Authorization header = %q, want %q", gotAuth, wantAuth)
+    }
+}
+
+func TestProbeHandlerIgnoresQueryTLSFiles(t *testing.T) {
+    target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/api/overview":
+            w.Write([]byte(`{"node": "rabbit@node1", "object_totals": {}, "queue_totals": {}, "message_stats": {}}`))
+        case "/api/queues":
+            w.Write([]byte(`[]`))
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer target.Close()
+
+    config := &Config{}
+    req := httptest.NewRequest("GET", "/probe?target="+target.URL+
+        "&module=overview&insecure_skip_verify=true&ca_file=/nonexistent/ca.pem&client_cert_file=/nonexistent/cert.pem&client_key_file=/nonexistent/key.pem", nil)
+    w := httptest.NewRecorder()
+
+    probeHandler(config).ServeHTTP(w, req)
+
+    // A malicious ca_file/client_cert_file/client_key_file in the query
+    // string must never reach newHTTPClient - if it did, building the
+    // client would fail (the paths don't exist), NewExporter would fall
+    // back to http.DefaultClient, which does not trust the test server's
+    // self-signed certificate, and the target would report up 0.
+    if w.Code != http.StatusOK {
+        t.Errorf("status = %d, want %d, body: %s", w.Code, http.StatusOK, w.Body.String())
+    }
+    if strings.Contains(w.Body.String(), "no such file") {
+        t.Errorf("query-string TLS file paths leaked into client construction: %s", w.Body.String())
+    }
+    wantUp := `rabbitmq_up{node="` + target.URL + `"} 1`
+    if !strings.Contains(w.Body.String(), wantUp) {
+        t.Errorf("body does not contain %q, body: %s", wantUp, w.Body.String())
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "net/http"
+    "regexp"
+)
+
+// objectFilter decides whether a named object should be scraped, based on an
+// optional include and skip regexp. An empty include matches everything; a
+// match against skip always wins.
+type objectFilter struct {
+    include *regexp.Regexp
+    skip    *regexp.Regexp
+}
+
+func (f objectFilter) allows(name string) bool {
+    if f.skip != nil && f.skip.MatchString(name) {
+        return false
+    }
+    if f.include != nil && !f.include.MatchString(name) {
+        return false
+    }
+    return true
+}
+
+func compileFilter(include, skip string) objectFilter {
+    var f objectFilter
+    if include != "" {
+        if re, err := regexp.Compile(include); err == nil {
+            f.include = re
+        } else {
+            log.Warnf("invalid include filter %q: %v", include, err)
+        }
+    }
+    if skip != "" {
+        if re, err := regexp.Compile(skip); err == nil {
+            f.skip = re
+        } else {
+            log.Warnf("invalid skip filter %q: %v", skip, err)
+        }
+    }
+    return f
+}
+
+func getQueuesStats(client *http.Client, node Node, vhostFilter, queueFilter objectFilter, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/queues")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    // Tally the broker-wide message total from the same response instead of
+    // getNumberOfMessages hitting /api/queues a second time; this total
+    // covers every queue, independent of the vhost/queue filters below.
+    totalMessages := 0.0
+    var totalsNode string
+    for i, q := range response {
+        qNode, _ := q["node"].(string)
+        if i == 0 {
+            totalsNode = qNode
+        }
+        totalMessages += floatValue(q["messages"])
+
+        vhost, _ := q["vhost"].(string)
+        name, _ := q["name"].(string)
+
+        if !vhostFilter.allows(vhost) || !queueFilter.allows(name) {
+            continue
+        }
+
+        m.queueMessages.WithLabelValues(vhost, name, qNode).Set(floatValue(q["messages"]))
+        m.queueMessagesReady.WithLabelValues(vhost, name, qNode).Set(floatValue(q["messages_ready"]))
+        m.queueMessagesUnacknowledged.WithLabelValues(vhost, name, qNode).Set(floatValue(q["messages_unacknowledged"]))
+        m.queueConsumers.WithLabelValues(vhost, name, qNode).Set(floatValue(q["consumers"]))
+        m.queueMemory.WithLabelValues(vhost, name, qNode).Set(floatValue(q["memory"]))
+
+        if messageBytes, ok := q["message_bytes"]; ok {
+            m.queueMessageBytes.WithLabelValues(vhost, name, qNode).Set(floatValue(messageBytes))
+        }
+
+        messageStats, _ := q["message_stats"].(map[string]interface{})
+        m.queueMessagesPublishedTotal.WithLabelValues(vhost, name, qNode).Set(floatValue(messageStats["publish"]))
+        m.queueMessagesDeliveredTotal.WithLabelValues(vhost, name, qNode).Set(floatValue(messageStats["deliver_get"]))
+        m.queueMessagesAckedTotal.WithLabelValues(vhost, name, qNode).Set(floatValue(messageStats["ack"]))
+        m.queueMessagesRedeliveredTotal.WithLabelValues(vhost, name, qNode).Set(floatValue(messageStats["redeliver"]))
+    }
+    if len(response) > 0 {
+        m.messagesTotal.WithLabelValues(totalsNode).Set(totalMessages)
+    }
+    return nil
+}
+
+func getExchangesStats(client *http.Client, node Node, vhostFilter objectFilter, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/exchanges")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    for _, e := range response {
+        vhost, _ := e["vhost"].(string)
+        name, _ := e["name"].(string)
+        exchangeType, _ := e["type"].(string)
+        exchangeNode, _ := e["node"].(string)
+
+        if !vhostFilter.allows(vhost) {
+            continue
+        }
+        // The default exchange is reported with an empty name by RabbitMQ.
+        if name == "" {
+            name = "(AMQP default)"
+        }
+
+        messageStats, _ := e["message_stats"].(map[string]interface{})
+        m.exchangeMessagesPublishedTotal.WithLabelValues(vhost, name, exchangeType, exchangeNode).Set(floatValue(messageStats["publish"]))
+        m.exchangeMessagesPublishedInTotal.WithLabelValues(vhost, name, exchangeType, exchangeNode).Set(floatValue(messageStats["publish_in"]))
+        m.exchangeMessagesPublishedOutTotal.WithLabelValues(vhost, name, exchangeType, exchangeNode).Set(floatValue(messageStats["publish_out"]))
+    }
+    return nil
+}
+
+func getVhostsStats(client *http.Client, node Node, vhostFilter objectFilter, m *metrics) error {
+    decoder, err := sendApiRequest(client, node, "/api/vhosts")
+    if err != nil {
+        return err
+    }
+    response, err := decodeObjArray(decoder)
+    if err != nil {
+        return err
+    }
+
+    for _, v := range response {
+        name, _ := v["name"].(string)
+        if !vhostFilter.allows(name) {
+            continue
+        }
+
+        m.vhostMessages.WithLabelValues(name).Set(floatValue(v["messages"]))
+        m.vhostMessagesReady.WithLabelValues(name).Set(floatValue(v["messages_ready"]))
+        m.vhostMessagesUnacknowledged.WithLabelValues(name).Set(floatValue(v["messages_unacknowledged"]))
+    }
+    return nil
+}
+
+// floatValue safely extracts a float64 from a decoded JSON value, returning
+// 0 for fields the management API omits (e.g. a queue with no consumers).
+func floatValue(v interface{}) float64 {
+    f, _ := v.(float64)
+    return f
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObjectFilterAllows(t *testing.T) {
+    cases := []struct {
+        name    string
+        include string
+        skip    string
+        target  string
+        want    bool
+    }{
+        {"no filters allows everything", "", "", "orders", true},
+        {"include matches", "^orders", "", "orders.created", true},
+        {"include does not match", "^orders", "", "payments.created", false},
+        {"skip matches", "", "^tmp\\.", "tmp.debug", false},
+        {"skip wins over include", "^tmp\\.", "^tmp\\.debug", "tmp.debug", false},
+        {"skip does not match, include matches", "^tmp\\.", "^tmp\\.debug", "tmp.other", true},
+    }
+
+    for _, c := range cases {
+        t.Run(c.name, func(t *testing.T) {
+            f := compileFilter(c.include, c.skip)
+            if got := f.allows(c.target); got != c.want {
+                t.Errorf("allows(%q) = %v, want %v", c.target, got, c.want)
+            }
+        })
+    }
+}
+
+func TestCompileFilterInvalidRegexp(t *testing.T) {
+    f := compileFilter("(", "")
+    if f.include != nil {
+        t.Errorf("expected invalid include regexp to be ignored, got %v", f.include)
+    }
+    if !f.allows("anything") {
+        t.Error("a filter with no usable include should allow everything")
+    }
+}
+
+const queuesFixture = `[
+    {"vhost": "/", "name": "orders", "node": "rabbit@node1", "messages": 10, "messages_ready": 7, "messages_unacknowledged": 3, "consumers": 2, "memory": 1024, "message_bytes": 2048, "message_stats": {"publish": 5, "deliver_get": 4, "ack": 3, "redeliver": 1}},
+    {"vhost": "/", "name": "tmp.debug", "node": "rabbit@node1", "messages": 1, "messages_ready": 1, "messages_unacknowledged": 0, "consumers": 0, "memory": 256, "message_stats": {"publish": 0, "deliver_get": 0, "ack": 0, "redeliver": 0}}
+]`
+
+func TestGetQueuesStatsFiltersAndTallies(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(queuesFixture))
+    }))
+    defer server.Close()
+
+    m := newMetrics()
+    vhostFilter := compileFilter("", "")
+    queueFilter := compileFilter("", "^tmp\\.")
+
+    if err := getQueuesStats(server.Client(), Node{Url: server.URL}, vhostFilter, queueFilter, m); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if got := testutil.ToFloat64(m.queueMessages.WithLabelValues("/", "orders", "rabbit@node1")); got != 10 {
+        t.Errorf("queueMessages[orders] = %v, want 10", got)
+    }
+    if testutil.ToFloat64(m.queueMessages.WithLabelValues("/", "tmp.debug", "rabbit@node1")) != 0 {
+        t.Error("filtered-out queue tmp.debug should not have been emitted")
+    }
+
+    // The broker-wide total is tallied across every queue in the response,
+    // independent of the queueFilter that skipped tmp.debug above.
+    if got := testutil.ToFloat64(m.messagesTotal.WithLabelValues("rabbit@node1")); got != 11 {
+        t.Errorf("messagesTotal = %v, want 11", got)
+    }
+}
+
+func TestGetQueuesStatsResetsStaleLabels(t *testing.T) {
+    first := true
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if first {
+            w.Write([]byte(queuesFixture))
+        } else {
+            w.Write([]byte(`[{"vhost": "/", "name": "orders", "node": "rabbit@node1", "messages": 10, "messages_ready": 7, "messages_unacknowledged": 3, "consumers": 2, "memory": 1024, "message_stats": {"publish": 5, "deliver_get": 4, "ack": 3, "redeliver": 1}}]`))
+        }
+    }))
+    defer server.Close()
+
+    m := newMetrics()
+    noFilter := compileFilter("", "")
+    node := Node{Url: server.URL}
+
+    if err := getQueuesStats(server.Client(), node, noFilter, noFilter, m); err != nil {
+        t.Fatalf("unexpected error on first scrape: %v", err)
+    }
+    if testutil.ToFloat64(m.queueMessages.WithLabelValues("/", "tmp.debug", "rabbit@node1")) != 1 {
+        t.Fatal("tmp.debug should be present after the first scrape")
+    }
+
+    first = false
+    m.resetPerObjectMetrics()
+    if err := getQueuesStats(server.Client(), node, noFilter, noFilter, m); err != nil {
+        t.Fatalf("unexpected error on second scrape: %v", err)
+    }
+
+    if testutil.ToFloat64(m.queueMessages.WithLabelValues("/", "tmp.debug", "rabbit@node1")) != 0 {
+        t.Error("tmp.debug was removed from the broker but its stale label set is still reporting a value")
+    }
+}
+
+func TestGetExchangesStatsDefaultExchangeNaming(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`[{"vhost": "/", "name": "", "type": "direct", "node": "rabbit@node1", "message_stats": {"publish": 3, "publish_in": 2, "publish_out": 1}}]`))
+    }))
+    defer server.Close()
+
+    m := newMetrics()
+    noFilter := compileFilter("", "")
+
+    if err := getExchangesStats(server.Client(), Node{Url: server.URL}, noFilter, m); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if got := testutil.ToFloat64(m.exchangeMessagesPublishedTotal.WithLabelValues("/", "(AMQP default)", "direct", "rabbit@node1")); got != 3 {
+        t.Errorf("exchangeMessagesPublishedTotal[(AMQP default)] = %v, want 3", got)
+    }
+}
+
+func TestGetVhostsStatsSkipsFiltered(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`[{"name": "/", "messages": 5, "messages_ready": 4, "messages_unacknowledged": 1}, {"name": "internal", "messages": 99, "messages_ready": 99, "messages_unacknowledged": 0}]`))
+    }))
+    defer server.Close()
+
+    m := newMetrics()
+    vhostFilter := compileFilter("", "^internal$")
+
+    if err := getVhostsStats(server.Client(), Node{Url: server.URL}, vhostFilter, m); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    if got := testutil.ToFloat64(m.vhostMessages.WithLabelValues("/")); got != 5 {
+        t.Errorf("vhostMessages[/] = %v, want 5", got)
+    }
+    if testutil.ToFloat64(m.vhostMessages.WithLabelValues("internal")) != 0 {
+        t.Error("filtered-out vhost internal should not have been emitted")
+    }
+}